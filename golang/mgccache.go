@@ -1,10 +1,17 @@
 package golang
 
 import (
+	"encoding/hex"
+	"go/token"
 	"go/types"
+	"golang.org/x/crypto/blake2b"
+	"io/ioutil"
+	"margo.sh/kimporter"
 	"margo.sh/mgpf"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -32,14 +39,45 @@ type mgcCacheEnt struct {
 type mgcCache struct {
 	sync.RWMutex
 	m map[mgcCacheKey]mgcCacheEnt
+
+	// dc, if non-nil, backs the in-memory cache with a persistent
+	// on-disk store of export data so the cache survives process
+	// restarts (e.g. the editor plugin being reloaded).
+	dc *kimporter.DiskCache
+}
+
+// SetDiskCache backs mc with a persistent on-disk cache of export data,
+// rooted at dir and bounded to maxBytes. Passing an empty dir disables it.
+func (mc *mgcCache) SetDiskCache(dir string, maxBytes int64) {
+	mc.Lock()
+	defer mc.Unlock()
+
+	if dir == "" {
+		mc.dc = nil
+		return
+	}
+	mc.dc = kimporter.NewDiskCache(dir, maxBytes)
 }
 
 func (mc *mgcCache) get(k mgcCacheKey) (mgcCacheEnt, bool) {
 	mc.RLock()
-	defer mc.RUnlock()
-
 	e, ok := mc.m[k]
-	return e, ok
+	dc := mc.dc
+	mc.RUnlock()
+	if ok || dc == nil {
+		return e, ok
+	}
+
+	fset := token.NewFileSet()
+	pkg, err := dc.Lookup(diskKey(k), fset, map[string]*types.Package{}, "")
+	if err != nil {
+		return mgcCacheEnt{}, false
+	}
+	e = mgcCacheEnt{Key: k, Pkg: pkg}
+	mc.Lock()
+	mc.m[k] = e
+	mc.Unlock()
+	return e, true
 }
 
 func (mc *mgcCache) put(e mgcCacheEnt) {
@@ -49,10 +87,54 @@ func (mc *mgcCache) put(e mgcCacheEnt) {
 	}
 
 	mc.Lock()
-	defer mc.Unlock()
-
 	mc.m[e.Key] = e
+	dc := mc.dc
+	mc.Unlock()
 	mgcDbgf("cache.put: %s %s\n", e.Key, mgpf.D(e.Dur))
+
+	if dc != nil {
+		if err := dc.Store(diskKey(e.Key), token.NewFileSet(), e.Pkg); err != nil {
+			mgcDbgf("cache.put: disk store failed for %s: %s\n", e.Key, err)
+		}
+	}
+}
+
+// diskKey maps a mgcCacheKey (an abs. filesystem path) to a filename-safe,
+// content-addressed key suitable for DiskCache: a hash of the directory's
+// .go files' contents, not just the directory path. Hashing only the path
+// (as an earlier version of this did) would let get() return a hit for a
+// directory whose on-disk source changed since the entry was written,
+// e.g. across the process restart the dc field's doc comment calls out
+// as the whole point of persisting to disk.
+func diskKey(k mgcCacheKey) string {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(k))
+	for _, fn := range dirGoFiles(string(k)) {
+		h.Write([]byte(fn))
+		if b, err := ioutil.ReadFile(fn); err == nil {
+			h.Write(b)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// dirGoFiles returns the .go files directly inside dir, sorted, for use
+// as diskKey's hash input. It reports nil for a dir it can't read rather
+// than failing the cache lookup outright.
+func dirGoFiles(dir string) []string {
+	ents, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var fns []string
+	for _, ent := range ents {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".go") {
+			continue
+		}
+		fns = append(fns, filepath.Join(dir, ent.Name()))
+	}
+	sort.Strings(fns)
+	return fns
 }
 
 func (mc *mgcCache) del(k mgcCacheKey) {
@@ -0,0 +1,79 @@
+package kimporter
+
+import (
+	"go/ast"
+	"go/token"
+	"runtime"
+	"sync"
+)
+
+// typeCheckBatch is shared by every Importer branch descended from the
+// same root Importer (see New and branch), analogous to gopls' batch in
+// gopls/internal/lsp/cache/check.go. It:
+//
+//   - caps CPU-bound work (parsing and type-checking) across the whole
+//     import graph with a single semaphore sized by runtime.NumCPU(),
+//     instead of each importDeps call spinning up its own worker pool
+//     sized by its own direct import count, which over-subscribes CPUs
+//     on deep graphs; and
+//   - shares a single *token.FileSet and a cache of already-parsed
+//     files, so sibling packages that import the same std packages
+//     don't reparse them.
+//
+// The semaphore is only held around actual CPU work (see Importer.check):
+// a goroutine blocked waiting for a sibling's *state to finish checking
+// elsewhere releases its slot first, so it waits only on its direct
+// imports rather than occupying a worker while idle.
+type typeCheckBatch struct {
+	sem chan struct{}
+
+	mu     sync.Mutex
+	fset   *token.FileSet
+	parsed map[string]*ast.File
+}
+
+func newTypeCheckBatch() *typeCheckBatch {
+	n := runtime.NumCPU()
+	if n < 1 {
+		n = 1
+	}
+	return &typeCheckBatch{
+		sem:    make(chan struct{}, n),
+		fset:   token.NewFileSet(),
+		parsed: map[string]*ast.File{},
+	}
+}
+
+// acquire reserves a worker slot for CPU-bound work, returning a func
+// that releases it. Callers must release before blocking on anything
+// other than CPU work.
+func (b *typeCheckBatch) acquire() func() {
+	b.sem <- struct{}{}
+	return func() { <-b.sem }
+}
+
+// file returns the cached *ast.File for fn if one of b's siblings has
+// already parsed it, otherwise it parses fn via parse and caches the
+// result for the rest of the batch.
+func (b *typeCheckBatch) file(fn string, parse func() (*ast.File, error)) (*ast.File, error) {
+	b.mu.Lock()
+	if af, ok := b.parsed[fn]; ok {
+		b.mu.Unlock()
+		return af, nil
+	}
+	b.mu.Unlock()
+
+	af, err := parse()
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	if cur, ok := b.parsed[fn]; ok {
+		af = cur
+	} else {
+		b.parsed[fn] = af
+	}
+	b.mu.Unlock()
+	return af, nil
+}
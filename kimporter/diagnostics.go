@@ -0,0 +1,152 @@
+package kimporter
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"margo.sh/mg"
+	"regexp"
+	"strings"
+)
+
+// Severity classifies a Diagnostic the same way types.Error.Soft does: a
+// hard error means the package didn't type-check cleanly; a soft one is
+// advisory (e.g. an unused import) and doesn't affect pkg.Complete().
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (sv Severity) String() string {
+	if sv == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// IssueCode is a machine-readable classification of a Diagnostic's
+// message, so editors can key quick-fixes off it instead of pattern
+// matching prose that may change between Go releases.
+type IssueCode string
+
+const (
+	CodeUndeclaredName IssueCode = "UndeclaredName"
+	CodeMissingImport  IssueCode = "MissingImport"
+	CodeRedeclared     IssueCode = "Redeclared"
+)
+
+// RelatedInfo is a secondary position relevant to a Diagnostic, e.g. the
+// site of a prior declaration in a "redeclared" error.
+type RelatedInfo struct {
+	Path    string
+	Row     int
+	Col     int
+	Message string
+}
+
+// Fix is a suggested remedy for a Diagnostic, e.g. the import path to
+// `go get` for a missing-import error.
+type Fix struct {
+	Title      string
+	ImportPath string
+}
+
+// Diagnostic is a types.Error enriched with a Severity, an IssueCode,
+// any RelatedInfo and a suggested Fix, so editors can surface quick-fixes
+// and multi-location diagnostics comparable to what gopls exposes.
+type Diagnostic struct {
+	Path     string
+	Row      int
+	Col      int
+	Message  string
+	Severity Severity
+	Code     IssueCode
+	Related  []RelatedInfo
+	Fix      *Fix
+}
+
+// Issue renders d as an mg.Issue, for editors that only understand the
+// single-location, code-less diagnostic shape mg.Issue has today. Code,
+// Fix and Related are folded into Message; Severity is not carried over,
+// so a warning and an error render alike.
+func (d Diagnostic) Issue() mg.Issue {
+	msg := d.Message
+	if d.Code != "" {
+		msg = fmt.Sprintf("[%s] %s", d.Code, msg)
+	}
+	if d.Fix != nil {
+		msg += fmt.Sprintf(" (try: %s)", d.Fix.Title)
+	}
+	for _, r := range d.Related {
+		msg += fmt.Sprintf("\n\t%s:%d:%d: %s", r.Path, r.Row+1, r.Col+1, r.Message)
+	}
+	return mg.Issue{
+		Path:    d.Path,
+		Row:     d.Row,
+		Col:     d.Col,
+		Message: msg,
+	}
+}
+
+var (
+	reUndeclared    = regexp.MustCompile(`^(undeclared name|undefined)\b`)
+	reMissingImport = regexp.MustCompile(`^(?:could not import|missing import|cannot find package)[^"]*"([^"]+)"`)
+	reRedeclared    = regexp.MustCompile(`\bredeclared\b`)
+)
+
+// newDiagnostic classifies te, a types.Error reported while
+// type-checking a package, into a Diagnostic. It does not handle the
+// continuation errors go/types reports for a multi-position error (e.g.
+// the second, tab-indented "other declaration of x" it emits alongside a
+// "redeclared in this block"); see appendDiagnostic for those.
+func newDiagnostic(fset *token.FileSet, te types.Error) Diagnostic {
+	d := Diagnostic{
+		Message:  te.Msg,
+		Severity: SeverityError,
+	}
+	if te.Soft {
+		d.Severity = SeverityWarning
+	}
+	if te.Pos.IsValid() {
+		p := fset.Position(te.Pos)
+		d.Path, d.Row, d.Col = p.Filename, p.Line-1, p.Column-1
+	}
+
+	switch {
+	case reUndeclared.MatchString(te.Msg):
+		d.Code = CodeUndeclaredName
+	case reRedeclared.MatchString(te.Msg):
+		d.Code = CodeRedeclared
+	default:
+		if m := reMissingImport.FindStringSubmatch(te.Msg); m != nil {
+			d.Code = CodeMissingImport
+			d.Fix = &Fix{Title: "go get " + m[1], ImportPath: m[1]}
+		}
+	}
+	return d
+}
+
+// appendDiagnostic is the entry point types.Config.Error should call for
+// every te it reports, in order. go/types doesn't fold a multi-position
+// error (e.g. "x redeclared in this block") into one Error() call with an
+// embedded "previous declaration at ..." suffix; instead it reports the
+// primary message, then a separate, independent types.Error whose Msg is
+// tab-indented (e.g. "\tother declaration of x") and whose Pos is the
+// other position. appendDiagnostic recognizes that continuation and folds
+// it into the immediately preceding Diagnostic's Related instead of
+// appending a second, disconnected diagnostic.
+func appendDiagnostic(diags []Diagnostic, fset *token.FileSet, te types.Error) []Diagnostic {
+	if msg := strings.TrimPrefix(te.Msg, "\t"); msg != te.Msg && len(diags) > 0 {
+		r := RelatedInfo{Message: msg}
+		if te.Pos.IsValid() {
+			p := fset.Position(te.Pos)
+			r.Path, r.Row, r.Col = p.Filename, p.Line-1, p.Column-1
+		}
+		last := &diags[len(diags)-1]
+		last.Related = append(last.Related, r)
+		return diags
+	}
+	return append(diags, newDiagnostic(fset, te))
+}
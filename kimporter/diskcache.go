@@ -0,0 +1,183 @@
+package kimporter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"go/token"
+	"go/types"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/tools/go/gcexportdata"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DiskCache is a content-addressed, on-disk cache of gc export data for
+// packages that have been successfully type-checked. Lookup and Store use
+// the key they are given. Importer keys its entries with cacheKey, a hash
+// of the package's source files plus the stateKey that produced them
+// (tags, GOOS/GOARCH, requested TypesInfo, ...), so a change to any of those
+// inputs misses the cache rather than returning stale types.
+//
+// This mirrors the approach gopls' filecache takes in
+// internal/lsp/cache/check.go: persisting export data to disk lets a fresh
+// editor session skip reparsing and rechecking the world on every cold
+// start.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+}
+
+// NewDiskCache returns a DiskCache rooted at dir. dir is created lazily on
+// first write. maxBytes bounds the total size of the cache; once exceeded,
+// the least-recently-used entries are evicted. maxBytes<=0 means unbounded.
+func NewDiskCache(dir string, maxBytes int64) *DiskCache {
+	return &DiskCache{dir: dir, maxBytes: maxBytes}
+}
+
+// cacheKey hashes ks's stateKey (which includes the package's import path
+// and dir) and its source file contents into a single content-addressed
+// key.
+func cacheKey(ks *state, files map[string][]byte) string {
+	b2, _ := blake2b.New256(nil)
+	fmtKeyFields(b2, ks.stateKey)
+
+	names := make(sort.StringSlice, 0, len(files))
+	for fn := range files {
+		names = append(names, fn)
+	}
+	names.Sort()
+	for _, fn := range names {
+		b2.Write([]byte(fn))
+		b2.Write(files[fn])
+	}
+	return hex.EncodeToString(b2.Sum(nil))
+}
+
+func fmtKeyFields(w io.Writer, k stateKey) {
+	fields := []string{
+		k.ImportPath, k.Dir, k.Tags, k.GOARCH, k.GOOS, k.GOROOT, k.GOPATH,
+		boolStr(k.CheckFuncs), boolStr(k.CheckImports), boolStr(k.Tests), boolStr(k.NoHash),
+	}
+	for _, f := range fields {
+		w.Write([]byte(f))
+		w.Write([]byte{0})
+	}
+	var ti [1]byte
+	ti[0] = byte(k.TypesInfo)
+	w.Write(ti[:])
+}
+
+func boolStr(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (dc *DiskCache) path(key string) string {
+	return filepath.Join(dc.dir, key[:2], key+".gcexport")
+}
+
+// Lookup reads the cached export data for key, if any, decoding it into a
+// *types.Package using fset and the already-resolved imports.
+func (dc *DiskCache) Lookup(key string, fset *token.FileSet, imports map[string]*types.Package, ipath string) (*types.Package, error) {
+	if dc.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(dc.path(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rd, err := gcexportdata.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := gcexportdata.Read(rd, fset, imports, ipath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	os.Chtimes(dc.path(key), now, now)
+	return pkg, nil
+}
+
+// Store writes pkg's export data under key, replacing any existing entry
+// via an atomic rename so concurrent readers never observe a partial file.
+func (dc *DiskCache) Store(key string, fset *token.FileSet, pkg *types.Package) error {
+	if dc.dir == "" || !pkg.Complete() {
+		return nil
+	}
+
+	pth := dc.path(key)
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := gcexportdata.Write(buf, fset, pkg); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(pth), ".tmp-gcexport-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), pth); err != nil {
+		return err
+	}
+
+	dc.evict()
+	return nil
+}
+
+// evict removes the least-recently-used entries until the cache's total
+// size is within maxBytes. It's best-effort: errors walking or removing
+// entries are ignored since the cache is always safe to rebuild.
+func (dc *DiskCache) evict() {
+	if dc.maxBytes <= 0 {
+		return
+	}
+
+	type ent struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var ents []ent
+	var total int64
+	filepath.Walk(dc.dir, func(pth string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		ents = append(ents, ent{pth, fi.Size(), fi.ModTime()})
+		total += fi.Size()
+		return nil
+	})
+	if total <= dc.maxBytes {
+		return
+	}
+
+	sort.Slice(ents, func(i, j int) bool { return ents[i].mod.Before(ents[j].mod) })
+	for _, e := range ents {
+		if total <= dc.maxBytes {
+			break
+		}
+		if os.Remove(e.path) == nil {
+			total -= e.size
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package kimporter
+
+import (
+	"bytes"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/gcexportdata"
+)
+
+// EncodePackage serializes pkg using the indexed gc export data format,
+// the same format cmd/compile/internal/importer and go/internal/gcimporter
+// use, and the same one DiskCache persists to disk. Unlike the older
+// bexport format, indexed export data decodes lazily by symbol, which
+// matters for large stdlib packages where only a handful of symbols are
+// ever resolved during completion.
+//
+// gcexportdata.Write already emits the indexed format on toolchains that
+// support it; go/internal/gcimporter's iexport/iimport encoders
+// themselves aren't importable outside the standard library and
+// x/tools, so EncodePackage/DecodePackage wrap gcexportdata rather than
+// reimplementing them.
+//
+// The result is suitable for handing pkg across a process boundary, e.g.
+// from an editor helper to a long-running margo daemon.
+func EncodePackage(pkg *Package) ([]byte, error) {
+	fset := pkg.Fset
+	if fset == nil {
+		fset = token.NewFileSet()
+	}
+	buf := &bytes.Buffer{}
+	if err := gcexportdata.Write(buf, fset, pkg.Package); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodePackage deserializes data, as produced by EncodePackage, into a
+// *Package. imports must already hold every package data's package
+// transitively imports, keyed by import path, same as
+// gcexportdata.Read requires.
+func DecodePackage(data []byte, imports map[string]*types.Package) (*Package, error) {
+	rd, err := gcexportdata.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	pkg, err := gcexportdata.Read(rd, fset, imports, "")
+	if err != nil {
+		return nil, err
+	}
+	return NewPackage(pkg, fset, nil, nil, nil), nil
+}
@@ -173,10 +173,11 @@ type state struct {
 		sync.Mutex
 		l []*state
 	}
-	mu   sync.Mutex
-	err  error
-	pkg  *Package
-	hash string
+	mu    sync.Mutex
+	err   error
+	pkg   *Package
+	hash  string
+	diags []Diagnostic
 }
 
 func (ks *state) invalidate(invAt int64) {
@@ -231,12 +232,39 @@ func (ks *state) result() (*Package, error) {
 type Config struct {
 	PackageSrc map[string][]byte
 
+	// Overlay maps virtual filenames to their content. Unlike SrcMap,
+	// an Overlay entry's file need not exist on disk: if its directory
+	// has no on-disk package, a *build.Package is synthesized from the
+	// overlay entries alone. This lets ImportPackage type-check an
+	// unsaved file, including one in a directory that hasn't been
+	// saved yet. In a directory that does have an on-disk package, only the
+	// files go/build finds on disk are checked: an Overlay entry there
+	// replaces an existing file's content but does not add a new file.
+	Overlay map[string][]byte
+
 	SrcMap        map[string][]byte
 	CheckFuncs    bool
 	CheckImports  bool
 	NoConcurrency bool
 	Tests         bool
 
+	// CacheDir, if non-empty, enables a persistent on-disk cache of gc
+	// export data for successfully checked packages, keyed by their
+	// source and stateKey. See DiskCache for details.
+	CacheDir string
+
+	// CacheMaxBytes bounds the total size of CacheDir. CacheMaxBytes<=0
+	// means unbounded.
+	CacheMaxBytes int64
+
+	// PreferExportData, if true, tries to resolve a package from its
+	// installed gc export data (via `go list -export`) before falling
+	// back to a full source-based check, even for packages outside
+	// GOROOT/src or a module cache. A package whose directory has a
+	// PackageSrc, SrcMap or Overlay entry is always checked from source.
+	// See Importer.useExportData.
+	PreferExportData bool
+
 	// TypesInfo specifies what, if any, package info to load
 	TypesInfo TypesInfo
 
@@ -245,14 +273,17 @@ type Config struct {
 }
 
 type Importer struct {
-	cfg  Config
-	mx   *mg.Ctx
-	bld  *build.Context
-	ks   *state
-	mp   *gopkg.ModPath
-	par  *Importer
-	tags string
-	hash string
+	cfg   Config
+	mx    *mg.Ctx
+	bld   *build.Context
+	ks    *state
+	mp    *gopkg.ModPath
+	par   *Importer
+	tags  string
+	hash  string
+	dc    *DiskCache
+	batch *typeCheckBatch
+	reg   *snapshotRegistry
 }
 
 func (kp *Importer) Import(path string) (*types.Package, error) {
@@ -260,7 +291,9 @@ func (kp *Importer) Import(path string) (*types.Package, error) {
 }
 
 func (kp *Importer) ImportFrom(ipath, srcDir string, mode types.ImportMode) (*types.Package, error) {
-	// TODO: add support for unsaved-files without a package
+	// mode is reserved for future use by go/types and must be 0.
+	// unsaved files without an on-disk package are instead supported via
+	// Config.Overlay, see ImportPackage.
 	if mode != 0 {
 		panic("non-zero import mode")
 	}
@@ -271,7 +304,10 @@ func (kp *Importer) ImportFrom(ipath, srcDir string, mode types.ImportMode) (*ty
 	return p.Package, nil
 }
 
-// ImportPackage import package with import path ipath relative to srcDir
+// ImportPackage import package with import path ipath relative to srcDir.
+// If ipath's package has no files on disk, it's resolved from Config.Overlay
+// instead, so an unsaved file (including one in a not-yet-created
+// directory) can still be type-checked.
 // NOTE: All Package fields except the underlying types.Package are optional.
 func (kp *Importer) ImportPackage(ipath, srcDir string) (*Package, error) {
 	if pkg := kp.importFakePkg(ipath); pkg != nil {
@@ -350,6 +386,7 @@ func (kp *Importer) importPkg(pp *gopkg.PkgPath) (pkg *Package, err error) {
 	// TODO: maybe lookup the state w/o TypesInfo.
 	// everything should be the same except one has types.Info
 	ks := kp.state(pp)
+	kp.reg.add(pp.Dir, pp.ImportPath, ks)
 	kx := kp.branch(ks, pp)
 	ks.mu.Lock()
 	defer ks.mu.Unlock()
@@ -365,20 +402,53 @@ func (kp *Importer) importPkg(pp *gopkg.PkgPath) (pkg *Package, err error) {
 }
 
 func (kp *Importer) check(ks *state, pp *gopkg.PkgPath, pkgSrc map[string][]byte) (*Package, error) {
-	fset := token.NewFileSet()
-	bp, filesMap, filesList, err := parseDir(kp.mx, kp.bld, fset, pp, kp.cfg.SrcMap, ks, pkgSrc)
+	// diags from an earlier check don't describe this one, whichever path
+	// below resolves the package.
+	ks.diags = nil
+	fset := kp.batch.fset
+	bp, err := findBuildPackage(kp.bld, pp, kp.cfg.Overlay)
 	if err != nil {
 		return nil, err
 	}
 
+	if kp.useExportData(pp) && !dirHasOverride(pp.Dir, pkgSrc, kp.cfg.SrcMap, kp.cfg.Overlay) {
+		if pkg, ok := kp.checkFromExportData(ks, bp, pp, fset); ok {
+			return pkg, nil
+		}
+		// fall through to a full source-based check below
+	}
+
+	// parseFiles does the real parser.ParseFile work, so it's bounded by
+	// the batch semaphore the same as the type-check stage below:
+	// otherwise, for any package not resolved via the export-data fast
+	// path above, concurrent parsing is unbounded.
+	parseRelease := kp.batch.acquire()
+	filesMap, filesList, err := parseFiles(kp.mx, fset, bp, kp.cfg.SrcMap, kp.cfg.Overlay, ks, pkgSrc, kp.batch)
+	parseRelease()
+	if err != nil {
+		return nil, err
+	}
+
+	// importDeps recurses into check for each dependency; it may block
+	// waiting on a sibling's *state mutex rather than doing CPU work, so
+	// the batch semaphore isn't held across it.
 	imports, err := kp.importDeps(ks, bp, fset, filesList)
 	if err != nil {
 		return nil, err
 	}
 
+	release := kp.batch.acquire()
+	defer release()
+
+	if kp.dc != nil {
+		if pkg, ok := kp.checkFromCache(ks, bp, fset, filesMap, filesList, imports, pkgSrc); ok {
+			return pkg, nil
+		}
+	}
+
 	if len(bp.CgoFiles) != 0 {
 		// TODO: fill in the type info. maybe we can just merge this into the pure-go check.
-		pkg, err := kp.importCgoPkg(pp, imports)
+		pkg, err := kp.importExportDataPkg(pp, imports)
 		if err == nil {
 			return NewPackage(pkg, fset, filesMap, nil, imports), err
 		}
@@ -386,12 +456,18 @@ func (kp *Importer) check(ks *state, pp *gopkg.PkgPath, pkgSrc map[string][]byte
 
 	defer kp.mx.Profile.Push(`Kim-Porter: typecheck(` + ks.ImportPath + `)`).Pop()
 	var hardErr error
+	var diags []Diagnostic
 	tc := types.Config{
 		FakeImportC:              true,
 		IgnoreFuncBodies:         !ks.CheckFuncs,
 		DisableUnusedImportCheck: !ks.CheckImports,
 		Error: func(err error) {
-			if te, ok := err.(types.Error); ok && !te.Soft && hardErr == nil {
+			te, ok := err.(types.Error)
+			if !ok {
+				return
+			}
+			diags = appendDiagnostic(diags, fset, te)
+			if !te.Soft && hardErr == nil {
 				hardErr = err
 			}
 		},
@@ -406,6 +482,10 @@ func (kp *Importer) check(ks *state, pp *gopkg.PkgPath, pkgSrc map[string][]byte
 	if err == nil && hardErr != nil {
 		err = hardErr
 	}
+	ks.diags = diags
+	if err == nil && kp.dc != nil {
+		kp.dc.Store(cacheKey(ks, filesMap2Bytes(fset, filesList, pkgSrc, kp.cfg.SrcMap, kp.cfg.Overlay)), fset, pkg)
+	}
 	switch {
 	case pkg == nil:
 		return nil, err
@@ -416,11 +496,124 @@ func (kp *Importer) check(ks *state, pp *gopkg.PkgPath, pkgSrc map[string][]byte
 	}
 }
 
-func (kp *Importer) importCgoPkg(pp *gopkg.PkgPath, imports map[string]*Package) (*types.Package, error) {
+// checkFromCache attempts to satisfy ks from kp.dc, decoding cached export
+// data for bp's files instead of type-checking from source. It reports
+// whether the cache was used.
+func (kp *Importer) checkFromCache(ks *state, bp *build.Package, fset *token.FileSet, filesMap map[string]*ast.File, filesList []*ast.File, imports map[string]*Package, pkgSrc map[string][]byte) (*Package, bool) {
+	m := make(map[string]*types.Package, len(imports))
+	for k, v := range imports {
+		m[k] = v.Package
+	}
+	key := cacheKey(ks, filesMap2Bytes(fset, filesList, pkgSrc, kp.cfg.SrcMap, kp.cfg.Overlay))
+	pkg, err := kp.dc.Lookup(key, fset, m, bp.ImportPath)
+	if err != nil || !pkg.Complete() {
+		return nil, false
+	}
+	return NewPackage(pkg, fset, filesMap, nil, imports), true
+}
+
+// filesMap2Bytes renders each file in filesList (or, if nil, reparses
+// nothing and returns an empty set) back to source bytes keyed by
+// filename, for use as DiskCache's content-addressed key input. It reads
+// through the same override-aware readSrc used by parseFiles, so an
+// unsaved buffer's cache key reflects its edited content rather than its
+// stale on-disk bytes: otherwise a check of overridden content could hit
+// (or poison) the cache entry for the saved file of the same name.
+func filesMap2Bytes(fset *token.FileSet, filesList []*ast.File, overrides ...map[string][]byte) map[string][]byte {
+	m := make(map[string][]byte, len(filesList))
+	for _, f := range filesList {
+		tf := fset.File(f.Pos())
+		if tf == nil {
+			continue
+		}
+		b, err := readSrc(tf.Name(), overrides...)
+		if err != nil {
+			continue
+		}
+		m[tf.Name()] = b
+	}
+	return m
+}
+
+// useExportData reports whether pp should be preferentially resolved from
+// the installed .a export data rather than rechecked from source: either
+// the caller opted in wholesale (Config.PreferExportData), or pp lives
+// under GOROOT/src or a module cache, both of which are read-only and so
+// can't have changed since they were built.
+func (kp *Importer) useExportData(pp *gopkg.PkgPath) bool {
+	if kp.cfg.PreferExportData {
+		return true
+	}
+	dir := filepath.Clean(pp.Dir)
+	if under(dir, filepath.Join(kp.bld.GOROOT, "src")) {
+		return true
+	}
+	for _, gp := range mgutil.PathList(kp.bld.GOPATH) {
+		if under(dir, filepath.Join(gp, "pkg", "mod")) {
+			return true
+		}
+	}
+	return false
+}
+
+func under(dir, root string) bool {
+	return dir == root || strings.HasPrefix(dir, root+string(filepath.Separator))
+}
+
+// dirHasOverride reports whether any of the given source overrides
+// target a file inside dir, in which case its installed export data (if
+// any) may no longer match its source and shouldn't be trusted.
+func dirHasOverride(dir string, overrides ...map[string][]byte) bool {
+	dir = filepath.Clean(dir)
+	for _, m := range overrides {
+		for fn := range m {
+			if filepath.Dir(fn) == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkFromExportData attempts to resolve pp's installed export data via
+// importExportDataPkg instead of rechecking it from source, resolving its
+// direct imports (which recurse through the same export-data-first path)
+// along the way. It reports whether this succeeded.
+func (kp *Importer) checkFromExportData(ks *state, bp *build.Package, pp *gopkg.PkgPath, fset *token.FileSet) (*Package, bool) {
+	// importDeps may block waiting on a sibling's *state mutex rather
+	// than doing CPU work, so the batch semaphore isn't held across it
+	// (same reasoning as the source-based path in check).
+	imports, err := kp.importDeps(ks, bp, fset, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	// importExportDataPkg forks a `go list -export` subprocess and
+	// decodes its output, which is exactly the kind of CPU/process-bound
+	// work the batch semaphore exists to bound; without it, a deep,
+	// export-data-heavy import graph fans out one subprocess per import
+	// with no cap, the same over-subscription problem the semaphore was
+	// introduced to fix for the source-check path.
+	release := kp.batch.acquire()
+	defer release()
+
+	pkg, err := kp.importExportDataPkg(pp, imports)
+	if err != nil {
+		return nil, false
+	}
+	return NewPackage(pkg, fset, nil, nil, imports), true
+}
+
+// importExportDataPkg resolves pp's *types.Package from its installed gc
+// export data (found via `go list -export`) instead of parsing and
+// type-checking its source. This is always used for cgo packages, whose
+// generated source isn't otherwise available, and is tried first for any
+// package when useExportData reports true.
+func (kp *Importer) importExportDataPkg(pp *gopkg.PkgPath, imports map[string]*Package) (*types.Package, error) {
 	name := `go`
 	args := []string{`list`, `-e`, `-export`, `-f={{.Export}}`, pp.Dir}
 	ctx, cancel := context.WithCancel(context.Background())
-	title := `Kim-Porter: importCgoPkg` + mgutil.QuoteCmd(name, args...) + `)`
+	title := `Kim-Porter: importExportDataPkg` + mgutil.QuoteCmd(name, args...) + `)`
 	defer kp.mx.Profile.Push(title).Pop()
 	defer kp.mx.Begin(mg.Task{Title: title, Cancel: cancel}).Done()
 
@@ -508,21 +701,16 @@ func (kp *Importer) importDeps(ks *state, bp *build.Package, fset *token.FileSet
 		}
 		return imports, nil
 	}
-	imps := make(chan string, len(paths))
-	for _, ipath := range paths {
-		imps <- ipath
-	}
-	close(imps)
+
+	// One goroutine per direct import, not a fixed-size worker pool:
+	// actual CPU work is bounded batch-wide by kp.batch's semaphore (see
+	// Importer.check), so a goroutine that's blocked waiting on a
+	// sibling's *state mutex doesn't tie up a worker that could
+	// otherwise make progress elsewhere in the graph.
 	errg := &errgroup.Group{}
-	for i := 0; i < mgutil.MinNumCPU(len(paths)); i++ {
-		errg.Go(func() error {
-			for ipath := range imps {
-				if err := doImport(ipath); err != nil {
-					return err
-				}
-			}
-			return nil
-		})
+	for _, ipath := range paths {
+		ipath := ipath
+		errg.Go(func() error { return doImport(ipath) })
 	}
 	return imports, errg.Wait()
 }
@@ -567,13 +755,18 @@ func New(mx *mg.Ctx, cfg *Config) *Importer {
 	bld := goutil.BuildContext(mx)
 	bld.BuildTags = append(bld.BuildTags, "netgo", "osusergo")
 	kp := &Importer{
-		mx:   mx,
-		bld:  bld,
-		tags: tagsStr(bld.BuildTags),
+		mx:    mx,
+		bld:   bld,
+		tags:  tagsStr(bld.BuildTags),
+		batch: newTypeCheckBatch(),
+		reg:   newSnapshotRegistry(),
 	}
 	if cfg != nil {
 		kp.cfg = *cfg
 		kp.hash = srcMapHash(cfg.SrcMap)
+		if cfg.CacheDir != "" {
+			kp.dc = NewDiskCache(cfg.CacheDir, cfg.CacheMaxBytes)
+		}
 	}
 	return kp
 }
@@ -0,0 +1,165 @@
+package kimporter
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"margo.sh/golang/gopkg"
+	"margo.sh/mg"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// findBuildPackage resolves the build.Package at pp.Dir. It's a cheap,
+// parse-free call (go/build only scans import declarations), so callers
+// can inspect bp.Imports etc. before deciding whether a full parse of
+// its files is warranted.
+//
+// When pp.Dir has no files on disk (e.g. a newly created, not-yet-saved
+// file, or a scratch buffer with no corresponding directory), bp is
+// synthesized from overlay instead, so ImportPackage can type-check it
+// without requiring a saved package on disk.
+func findBuildPackage(bld *build.Context, pp *gopkg.PkgPath, overlay map[string][]byte) (*build.Package, error) {
+	bp, err := bld.ImportDir(pp.Dir, 0)
+	if err != nil || (len(bp.GoFiles) == 0 && len(bp.CgoFiles) == 0) {
+		if ovbp := overlayPackage(pp.Dir, pp.ImportPath, overlay); ovbp != nil {
+			return ovbp, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return bp, nil
+}
+
+// parseFiles parses bp's Go files (and, if ks.Tests, its test files),
+// applying srcMap/overlay overrides over their on-disk content and
+// sharing already-parsed files via batch.
+func parseFiles(mx *mg.Ctx, fset *token.FileSet, bp *build.Package, srcMap, overlay map[string][]byte, ks *state, pkgSrc map[string][]byte, batch *typeCheckBatch) (map[string]*ast.File, []*ast.File, error) {
+	defer mx.Profile.Push(`Kim-Porter: parseFiles(` + bp.Dir + `)`).Pop()
+
+	names := make([]string, 0, len(bp.GoFiles)+len(bp.CgoFiles)+len(bp.TestGoFiles))
+	names = append(names, bp.GoFiles...)
+	names = append(names, bp.CgoFiles...)
+	if ks.Tests {
+		names = append(names, bp.TestGoFiles...)
+	}
+	sort.Strings(names)
+
+	filesMap := make(map[string]*ast.File, len(names))
+	filesList := make([]*ast.File, 0, len(names))
+	for _, nm := range names {
+		fn := filepath.Join(bp.Dir, nm)
+		parse := func() (*ast.File, error) {
+			src, err := readSrc(fn, pkgSrc, srcMap, overlay)
+			if err != nil {
+				return nil, err
+			}
+			return parser.ParseFile(fset, fn, src, parser.ParseComments)
+		}
+
+		var af *ast.File
+		var err error
+		if hasOverride(fn, pkgSrc, srcMap, overlay) {
+			// Overridden content (e.g. an unsaved edit) is specific to
+			// this check, so it can't be shared via the batch cache.
+			af, err = parse()
+		} else {
+			af, err = batch.file(fn, parse)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		filesMap[nm] = af
+		filesList = append(filesList, af)
+	}
+	return filesMap, filesList, nil
+}
+
+func hasOverride(fn string, overrides ...map[string][]byte) bool {
+	for _, m := range overrides {
+		if _, ok := m[fn]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readSrc returns the content fn should be parsed with, preferring, in
+// order, any of the given overrides over fn's on-disk content. Overrides
+// are checked in the order given, so callers pass the most
+// user-authoritative map (e.g. per-call PackageSrc) first.
+func readSrc(fn string, overrides ...map[string][]byte) ([]byte, error) {
+	for _, m := range overrides {
+		if src, ok := m[fn]; ok {
+			return src, nil
+		}
+	}
+	return ioutil.ReadFile(fn)
+}
+
+// overlayPackage synthesizes a minimal *build.Package for dir from
+// virtual files in overlay, for directories with no on-disk package, e.g.
+// an unsaved file in a brand new directory. It reports nil if overlay
+// holds no .go files for dir.
+func overlayPackage(dir, ipath string, overlay map[string][]byte) *build.Package {
+	dir = filepath.Clean(dir)
+	var goFiles []string
+	for fn := range overlay {
+		if filepath.Dir(fn) != dir || !strings.HasSuffix(fn, ".go") || strings.HasSuffix(fn, "_test.go") {
+			continue
+		}
+		goFiles = append(goFiles, filepath.Base(fn))
+	}
+	if len(goFiles) == 0 {
+		return nil
+	}
+	sort.Strings(goFiles)
+	return &build.Package{
+		Dir:        dir,
+		ImportPath: ipath,
+		Name:       filepath.Base(dir),
+		GoFiles:    goFiles,
+		Imports:    overlayImports(dir, goFiles, overlay),
+	}
+}
+
+// overlayImports parses just the import declarations of each of goFiles
+// in dir, so the *build.Package overlayPackage synthesizes carries real
+// Imports the same way bld.ImportDir would for an on-disk package.
+//
+// Without this, check's eager importDeps warmup (which runs before
+// kp.batch.acquire specifically so the semaphore is only held around CPU
+// work) has nothing to warm for an overlay package, since it walks
+// bp.Imports. The later tc.Check call, made while a batch slot is held,
+// would then call back into kp.Import for each of the file's real,
+// never-warmed imports; if one of those hasn't been resolved elsewhere
+// yet, that recurses into a fresh check that blocks trying to acquire a
+// second slot from the same already-slot-holding goroutine. With enough
+// concurrent overlay checks in flight, every slot can end up held by a
+// goroutine blocked on one more slot that will never free.
+func overlayImports(dir string, goFiles []string, overlay map[string][]byte) []string {
+	fset := token.NewFileSet()
+	seen := map[string]bool{}
+	var imports []string
+	for _, nm := range goFiles {
+		fn := filepath.Join(dir, nm)
+		af, err := parser.ParseFile(fset, fn, overlay[fn], parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, im := range af.Imports {
+			path, err := strconv.Unquote(im.Path.Value)
+			if err != nil || seen[path] {
+				continue
+			}
+			seen[path] = true
+			imports = append(imports, path)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
@@ -0,0 +1,160 @@
+package kimporter
+
+import (
+	"margo.sh/memo"
+	"sync"
+)
+
+// PackageKey identifies a package within a Snapshot by both its directory
+// and import path, the same way globalState keys a *state, rather than by
+// import path alone: two different directories (a vendored/module-cache
+// duplicate, or an overlay scratch package sharing a path with a real
+// one) can resolve to the same import path within one Importer's
+// lifetime, and collapsing them down to one ImportPath-keyed entry
+// silently drops whichever one loses the map-iteration race.
+type PackageKey struct {
+	Dir        string
+	ImportPath string
+}
+
+// snapshotRegistry tracks every *state an Importer (and its branches, see
+// branch) has resolved, so Importer.Snapshot can assemble a consistent
+// view of the import graph without re-walking it from scratch.
+type snapshotRegistry struct {
+	mu sync.Mutex
+	m  map[PackageKey]*state
+}
+
+func newSnapshotRegistry() *snapshotRegistry {
+	return &snapshotRegistry{m: map[PackageKey]*state{}}
+}
+
+func (r *snapshotRegistry) add(dir, ipath string, ks *state) {
+	r.mu.Lock()
+	r.m[PackageKey{dir, ipath}] = ks
+	r.mu.Unlock()
+}
+
+func (r *snapshotRegistry) states() []*state {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := make([]*state, 0, len(r.m))
+	for _, ks := range r.m {
+		l = append(l, ks)
+	}
+	return l
+}
+
+// Snapshot is a consistent, point-in-time view of the packages an
+// Importer has resolved, captured at a given memo.InvAt() tick. Two
+// Snapshots taken before and after an Invalidate observe different
+// results even though they're derived from the same Importer.
+type Snapshot struct {
+	tick  int64
+	pkgs  map[PackageKey]*Package
+	diags map[PackageKey][]Diagnostic
+}
+
+// Tick returns the memo.InvAt() tick the snapshot was captured at.
+func (sn *Snapshot) Tick() int64 {
+	return sn.tick
+}
+
+// Packages returns the packages known to the snapshot, keyed by PackageKey.
+func (sn *Snapshot) Packages() map[PackageKey]*Package {
+	m := make(map[PackageKey]*Package, len(sn.pkgs))
+	for k, pkg := range sn.pkgs {
+		m[k] = pkg
+	}
+	return m
+}
+
+// ReverseDeps returns the import paths, within the snapshot, of packages
+// that directly or transitively import ipath.
+func (sn *Snapshot) ReverseDeps(ipath string) []string {
+	var rdeps []string
+	for k, pkg := range sn.pkgs {
+		if k.ImportPath == ipath {
+			continue
+		}
+		if importsTransitively(pkg, ipath, map[*Package]bool{}) {
+			rdeps = append(rdeps, k.ImportPath)
+		}
+	}
+	return rdeps
+}
+
+func importsTransitively(pkg *Package, ipath string, seen map[*Package]bool) bool {
+	if pkg == nil || seen[pkg] {
+		return false
+	}
+	seen[pkg] = true
+	for path, dep := range pkg.Imports {
+		if path == ipath || importsTransitively(dep, ipath, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diagnostics returns the Diagnostics encountered resolving the
+// snapshot's packages, keyed by PackageKey. A package with no entry
+// checked cleanly.
+func (sn *Snapshot) Diagnostics() map[PackageKey][]Diagnostic {
+	m := make(map[PackageKey][]Diagnostic, len(sn.diags))
+	for k, ds := range sn.diags {
+		m[k] = append([]Diagnostic(nil), ds...)
+	}
+	return m
+}
+
+// Snapshot captures the current, consistent view of the packages kp has
+// resolved so far. Packages invalidated since they were last checked are
+// left out.
+func (kp *Importer) Snapshot() *Snapshot {
+	pkgs := map[PackageKey]*Package{}
+	diags := map[PackageKey][]Diagnostic{}
+	for _, ks := range kp.reg.states() {
+		ks.mu.Lock()
+		pkg, ds := ks.pkg, ks.diags
+		valid := ks.chkAt.N() > ks.invAt.N()
+		ks.mu.Unlock()
+		if !valid {
+			continue
+		}
+
+		key := PackageKey{Dir: ks.Dir, ImportPath: ks.ImportPath}
+		if pkg != nil {
+			pkgs[key] = pkg
+		}
+		if len(ds) != 0 {
+			diags[key] = ds
+		}
+	}
+	return &Snapshot{tick: memo.InvAt(), pkgs: pkgs, diags: diags}
+}
+
+// Invalidate synchronously invalidates the package rooted at dir (e.g.
+// because a file under dir was just saved) along with, transitively,
+// every package that imports it, via the same reverse-dependency graph
+// (imby) state.invalidate already walks.
+//
+// This is the same invalidation a VFS file-change already triggers
+// lazily: every *state implements memo.V, and mx.VFS.ReadMemo (see
+// Importer.state) calls InvalidateMemo, which calls invalidate, the
+// moment the VFS notices dir's content changed — that's the actual hook
+// into VFS file-change events, and it already cascades the imby graph
+// synchronously with no extra wiring needed here. Invalidate exists
+// alongside that for callers who can act on a change before the VFS
+// layer gets around to it (e.g. an editor that wants dependents marked
+// stale the instant it writes a buffer to disk, without waiting a tick
+// for the VFS to notice).
+func (kp *Importer) Invalidate(dir string) {
+	invAt := memo.InvAt()
+	for _, ks := range kp.reg.states() {
+		if ks.Dir == dir {
+			ks.invalidate(invAt)
+		}
+	}
+}